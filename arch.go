@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+var archQemuExec = map[string]string{
+	"x86_64":  "qemu-system-x86_64",
+	"aarch64": "qemu-system-aarch64",
+	"s390x":   "qemu-system-s390x",
+}
+
+var archMachine = map[string]string{
+	"x86_64":  "q35",
+	"aarch64": "virt",
+	"s390x":   "s390-ccw-virtio",
+}
+
+func hostArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "s390x":
+		return "s390x"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+func qemuExec(config *VMConfig) (string, error) {
+	arch := config.Arch
+	if arch == "" {
+		arch = hostArch()
+	}
+	exec, ok := archQemuExec[arch]
+	if !ok {
+		return "", fmt.Errorf("unsupported arch %q", arch)
+	}
+	return exec, nil
+}
+
+func defaultAccel(arch string) string {
+	if arch != "" && arch != hostArch() {
+		return "tcg"
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		if _, err := os.Stat("/dev/kvm"); err == nil {
+			return "kvm"
+		}
+	case "darwin":
+		return "hvf"
+	}
+
+	return "tcg"
+}
+
+func defaultMachine(arch string) string {
+	if arch == "" {
+		arch = hostArch()
+	}
+	if machine, ok := archMachine[arch]; ok {
+		return machine
+	}
+	return "pc"
+}
+
+// "host" only makes sense when the accelerator actually runs on the host CPU.
+func defaultCPU(arch, accel string) string {
+	if accel == "kvm" || accel == "hvf" {
+		return "host"
+	}
+	switch arch {
+	case "aarch64":
+		return "cortex-a72"
+	default:
+		return "max"
+	}
+}
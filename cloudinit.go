@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+)
+
+// Ignition is delivered via fw_cfg instead of a seed ISO, and is mutually
+// exclusive with the NoCloud fields.
+type CloudInit struct {
+	UserData      string `json:"user_data,omitempty"`
+	MetaData      string `json:"meta_data,omitempty"`
+	NetworkConfig string `json:"network_config,omitempty"`
+	Ignition      string `json:"ignition,omitempty"`
+}
+
+var isoWriters = []string{"genisoimage", "mkisofs"}
+
+func seedISOPath(name string) (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("getting current user: %w", err)
+	}
+	return filepath.Join(usr.HomeDir, DiskDir, name+"-seed.iso"), nil
+}
+
+func cloudInitArgs(config *VMConfig) ([]string, error) {
+	ci := config.CloudInit
+	if ci == nil {
+		return nil, nil
+	}
+
+	if ci.Ignition != "" {
+		return []string{"-fw_cfg", fmt.Sprintf("name=opt/com.coreos/config,file=%s", ci.Ignition)}, nil
+	}
+
+	seedPath, err := generateSeedISO(config.Name, ci)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{"-drive", fmt.Sprintf("if=virtio,format=raw,file=%s,media=cdrom", seedPath)}, nil
+}
+
+func generateSeedISO(name string, ci *CloudInit) (string, error) {
+	seedPath, err := seedISOPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(seedPath), os.ModePerm); err != nil {
+		return "", fmt.Errorf("creating disk directory: %w", err)
+	}
+
+	srcDir, err := os.MkdirTemp("", "qmgr-seed-"+name)
+	if err != nil {
+		return "", fmt.Errorf("creating seed staging directory: %w", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	userData := ci.UserData
+	if userData == "" {
+		userData = filepath.Join(srcDir, "user-data")
+		if err := os.WriteFile(userData, []byte("#cloud-config\n"), 0644); err != nil {
+			return "", fmt.Errorf("writing default user-data: %w", err)
+		}
+	}
+
+	metaData := ci.MetaData
+	if metaData == "" {
+		metaData = filepath.Join(srcDir, "meta-data")
+		contents := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", name, name)
+		if err := os.WriteFile(metaData, []byte(contents), 0644); err != nil {
+			return "", fmt.Errorf("writing default meta-data: %w", err)
+		}
+	}
+
+	// Graft points ("iso-path=host-path") land each file at its
+	// NoCloud-expected name regardless of its source path.
+	args := []string{"-output", seedPath, "-volid", "cidata", "-joliet", "-rock", "-graft-points",
+		"user-data=" + userData, "meta-data=" + metaData}
+	if ci.NetworkConfig != "" {
+		args = append(args, "network-config="+ci.NetworkConfig)
+	}
+
+	var lastErr error
+	for _, writer := range isoWriters {
+		if _, err := exec.LookPath(writer); err != nil {
+			continue
+		}
+		if err := exec.Command(writer, args...).Run(); err != nil {
+			lastErr = fmt.Errorf("running %s: %w", writer, err)
+			continue
+		}
+		return seedPath, nil
+	}
+	if lastErr != nil {
+		return "", lastErr
+	}
+	return "", fmt.Errorf("no iso writer found (need genisoimage or mkisofs)")
+}
+
+// sshPubKey prefers ed25519 over rsa.
+func sshPubKey() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("getting current user: %w", err)
+	}
+
+	for _, name := range []string{"id_ed25519.pub", "id_rsa.pub"} {
+		path := filepath.Join(usr.HomeDir, ".ssh", name)
+		contents, err := os.ReadFile(path)
+		if err == nil {
+			return string(contents), nil
+		}
+	}
+
+	return "", fmt.Errorf("no ssh public key found in ~/.ssh")
+}
+
+func writeDefaultCloudInitUserData(name string) (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("getting current user: %w", err)
+	}
+
+	pubKey, err := sshPubKey()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(usr.HomeDir, DiskDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("creating disk directory: %w", err)
+	}
+
+	path := filepath.Join(dir, name+"-user-data.yaml")
+	contents := fmt.Sprintf("#cloud-config\nssh_authorized_keys:\n  - %s", pubKey)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		return "", fmt.Errorf("writing user-data: %w", err)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+)
+
+func runDiskCommand(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: disk create|resize|snapshot|clone|convert|info ...")
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: disk create <name> [size]")
+		}
+		size := "64G"
+		if len(args) > 2 {
+			size = args[2]
+		}
+		path, err := newDisk(args[1], size)
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+
+	case "resize":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: disk resize <name> <+size|size>")
+		}
+		return resizeDisk(args[1], args[2])
+
+	case "snapshot":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: disk snapshot <name> <tag> | disk snapshot ls <name> | disk snapshot rm <name> <tag>")
+		}
+		switch args[1] {
+		case "ls":
+			if len(args) < 3 {
+				return fmt.Errorf("usage: disk snapshot ls <name>")
+			}
+			return listSnapshots(args[2])
+		case "rm":
+			if len(args) < 4 {
+				return fmt.Errorf("usage: disk snapshot rm <name> <tag>")
+			}
+			return removeSnapshot(args[2], args[3])
+		default:
+			if len(args) < 3 {
+				return fmt.Errorf("usage: disk snapshot <name> <tag>")
+			}
+			return snapshotDisk(args[1], args[2])
+		}
+
+	case "clone":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: disk clone <src> <dst>")
+		}
+		return cloneDisk(args[1], args[2])
+
+	case "convert":
+		if len(args) < 4 || args[2] != "--format" {
+			return fmt.Errorf("usage: disk convert <name> --format vmdk|vdi|raw")
+		}
+		path, err := convertDisk(args[1], args[3])
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+
+	case "info":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: disk info <name>")
+		}
+		return diskInfo(args[1])
+
+	default:
+		return fmt.Errorf("unknown disk subcommand %q", args[0])
+	}
+
+	return nil
+}
+
+func diskPath(name string) (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("getting current user: %w", err)
+	}
+	return filepath.Join(usr.HomeDir, DiskDir, name+".qcow2"), nil
+}
+
+func resizeDisk(name, size string) error {
+	path, err := diskPath(name)
+	if err != nil {
+		return err
+	}
+	if err := exec.Command(QemuImg, "resize", path, size).Run(); err != nil {
+		return fmt.Errorf("resizing disk image: %w", err)
+	}
+	return nil
+}
+
+func snapshotDisk(name, tag string) error {
+	path, err := diskPath(name)
+	if err != nil {
+		return err
+	}
+	if err := exec.Command(QemuImg, "snapshot", "-c", tag, path).Run(); err != nil {
+		return fmt.Errorf("creating disk snapshot: %w", err)
+	}
+	return recordSnapshot(name, tag)
+}
+
+// recordSnapshot appends tag to the VM's config, if not already recorded,
+// so validateSnapshotTag finds it regardless of which path created the
+// snapshot (offline `disk snapshot` or live `snapshot` via QMP savevm).
+func recordSnapshot(name, tag string) error {
+	config, err := readConfig(name)
+	if err != nil {
+		return err
+	}
+	for _, existing := range config.Snapshots {
+		if existing == tag {
+			return nil
+		}
+	}
+	config.Snapshots = append(config.Snapshots, tag)
+	_, err = writeConfig(name, config)
+	return err
+}
+
+func listSnapshots(name string) error {
+	path, err := diskPath(name)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(QemuImg, "snapshot", "-l", path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("listing disk snapshots: %w", err)
+	}
+	return nil
+}
+
+func removeSnapshot(name, tag string) error {
+	path, err := diskPath(name)
+	if err != nil {
+		return err
+	}
+	if err := exec.Command(QemuImg, "snapshot", "-d", tag, path).Run(); err != nil {
+		return fmt.Errorf("removing disk snapshot: %w", err)
+	}
+
+	config, err := readConfig(name)
+	if err != nil {
+		return err
+	}
+	remaining := config.Snapshots[:0]
+	for _, existing := range config.Snapshots {
+		if existing != tag {
+			remaining = append(remaining, existing)
+		}
+	}
+	config.Snapshots = remaining
+	_, err = writeConfig(name, config)
+	return err
+}
+
+func cloneDisk(src, dst string) error {
+	srcPath, err := diskPath(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := diskPath(dst)
+	if err != nil {
+		return err
+	}
+	if err := exec.Command(QemuImg, "create", "-f", "qcow2", "-b", srcPath, "-F", "qcow2", dstPath).Run(); err != nil {
+		return fmt.Errorf("cloning disk image: %w", err)
+	}
+	return nil
+}
+
+func convertDisk(name, format string) (string, error) {
+	path, err := diskPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("getting current user: %w", err)
+	}
+	outPath := filepath.Join(usr.HomeDir, DiskDir, name+"."+format)
+
+	if err := exec.Command(QemuImg, "convert", "-O", format, path, outPath).Run(); err != nil {
+		return "", fmt.Errorf("converting disk image: %w", err)
+	}
+	return outPath, nil
+}
+
+func diskInfo(name string) error {
+	path, err := diskPath(name)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(QemuImg, "info", path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("reading disk info: %w", err)
+	}
+	return nil
+}
+
+func validateSnapshotTag(config *VMConfig, tag string) error {
+	for _, existing := range config.Snapshots {
+		if existing == tag {
+			return nil
+		}
+	}
+	return fmt.Errorf("no snapshot %q recorded for %s (see: disk snapshot ls %s)", tag, config.Name, config.Name)
+}
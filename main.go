@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -9,6 +10,9 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+
+	"github.com/dantecatalfamo/qmgr/qmp"
+	"github.com/dantecatalfamo/qmgr/templates"
 )
 
 type Port struct {
@@ -19,25 +23,64 @@ type Port struct {
 type Drive struct {
 	Path string `json:"path"`
 	Type string `json:"type"`
+	// Interface overrides the bus a qcow2 drive is attached on (virtio,
+	// ide, or usb-storage). Empty means virtio.
+	Interface string `json:"interface,omitempty"`
 }
 
 type VMConfig struct {
 	Name       string  `json:"name"`
+	UUID       string  `json:"uuid,omitempty"`
 	Memory     string  `json:"memory"`
 	Drives     []Drive `json:"drives"`
 	Ports      []Port  `json:"ports"`
 	Cores      uint    `json:"cores"`
 	Fullscreen bool    `json:"fullscreen"`
+
+	// NICs are the VM's network interfaces. When empty, launchVM falls
+	// back to a single user-mode NIC built from Ports.
+	NICs []NIC `json:"nics,omitempty"`
+
+	// Arch is the guest architecture in qemu-system-* form (x86_64,
+	// aarch64, s390x). Empty means "same as the host".
+	Arch string `json:"arch,omitempty"`
+	// Accel is the qemu accelerator (kvm, hvf, tcg). Empty means
+	// autodetect from Arch and the host.
+	Accel string `json:"accel,omitempty"`
+	// Machine is the -machine type. Empty means a per-arch default
+	// (q35 on x86_64, virt on aarch64).
+	Machine string `json:"machine,omitempty"`
+	// CPU is the -cpu model. Empty means a per-arch/accel default.
+	CPU string `json:"cpu,omitempty"`
+
+	// BIOS, when set, is passed as -bios, e.g. for an edk2 UEFI image.
+	BIOS string `json:"bios,omitempty"`
+	// Pflash is a list of -drive if=pflash entries, used for UEFI
+	// code/vars on machines (like virt) that need them instead of -bios.
+	Pflash []string `json:"pflash,omitempty"`
+
+	// CloudInit, when set, seeds the VM with NoCloud or Ignition
+	// first-boot configuration.
+	CloudInit *CloudInit `json:"cloud_init,omitempty"`
+
+	// Snapshots lists the tags of internal qcow2 snapshots taken with
+	// `disk snapshot` or the live `snapshot` command, so
+	// `run --snapshot <tag>` can validate and boot from one.
+	Snapshots []string `json:"snapshots,omitempty"`
 }
 
 const ConfigDir = ".config/qmgr/configs"
 const DiskDir = ".config/qmgr/disks"
-const QemuExec = "qemu-system-x86_64"
+const RunDir = ".config/qmgr/run"
 const QemuImg = "qemu-img"
 
+// DefaultOVMF is the OVMF UEFI firmware path used by templates that need
+// it, as shipped by most Linux distro qemu/ovmf packages.
+const DefaultOVMF = "/usr/share/OVMF/OVMF_CODE.fd"
+
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Fprintln(os.Stderr, "no command given\n  run <name>\n  list\n  create <name>\n  edit <name>")
+		fmt.Fprintln(os.Stderr, "no command given\n  run <name> [--snapshot <tag>]\n  list\n  create <name> [--template <name>] [--cloud-init]\n  edit <name>\n  stop <name>\n  kill <name>\n  status <name>\n  reset <name>\n  snapshot <name> <tag>\n  restore <name> <tag>\n  templates list\n  disk create|resize|snapshot|clone|convert|info ...")
 		return
 	}
 
@@ -56,12 +99,26 @@ func main() {
 			fmt.Fprintln(os.Stderr, "no run name")
 			os.Exit(1)
 		}
+
+		snapshotTag := ""
+		runArgs := os.Args[3:]
+		for i := 0; i < len(runArgs); i++ {
+			if runArgs[i] == "--snapshot" {
+				i++
+				if i >= len(runArgs) {
+					fmt.Fprintln(os.Stderr, "--snapshot requires a tag")
+					os.Exit(1)
+				}
+				snapshotTag = runArgs[i]
+			}
+		}
+
 		config, err := readConfig(os.Args[2])
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		if err := launchVM(config); err != nil {
+		if err := launchVM(config, snapshotTag); err != nil {
 			panic(err)
 		}
 
@@ -70,41 +127,121 @@ func main() {
 			fmt.Fprintln(os.Stderr, "no create name")
 			os.Exit(1)
 		}
+		name := os.Args[2]
+
+		cloudInitFlag := false
+		templateName := ""
+		var rest []string
+		args := os.Args[3:]
+		for i := 0; i < len(args); i++ {
+			switch args[i] {
+			case "--cloud-init":
+				cloudInitFlag = true
+			case "--template":
+				i++
+				if i >= len(args) {
+					fmt.Fprintln(os.Stderr, "--template requires a name")
+					os.Exit(1)
+				}
+				templateName = args[i]
+			default:
+				rest = append(rest, args[i])
+			}
+		}
+
+		var tmpl templates.Template
+		if templateName != "" {
+			t, ok := templates.Get(templateName)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "unknown template %q\n", templateName)
+				os.Exit(1)
+			}
+			tmpl = t
+			cloudInitFlag = cloudInitFlag || tmpl.CloudInit
+		}
 
 		size := "64G"
-		if len(os.Args) > 3 {
-			size = os.Args[3]
+		if len(rest) > 0 {
+			size = rest[0]
+		}
+
+		var diskPath string
+		var err error
+		if tmpl.ImageURL != "" {
+			usr, uErr := user.Current()
+			if uErr != nil {
+				fmt.Fprintln(os.Stderr, uErr)
+				os.Exit(1)
+			}
+			diskPath = filepath.Join(usr.HomeDir, DiskDir, name+".qcow2")
+			if err := templates.Fetch(tmpl, diskPath); err != nil {
+				fmt.Fprintln(os.Stderr, "fetching template image:", err)
+				os.Exit(1)
+			}
+		} else {
+			diskPath, err = newDisk(name, size)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
 		}
 
-		diskPath, err := newDisk(os.Args[2], size)
+		uuid, err := newUUID()
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		var cloudInit *CloudInit
+		if cloudInitFlag {
+			userData, err := writeDefaultCloudInitUserData(name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "cloud-init:", err)
+				os.Exit(1)
+			}
+			cloudInit = &CloudInit{UserData: userData}
+		}
+
+		memory := "2G"
+		var cores uint
+		nicModel := ""
+		bios := ""
+		drives := []Drive{
+			{Type: "img"},
+			{Type: "qcow2", Path: diskPath, Interface: tmpl.DiskInterface},
+			{Type: "iso"},
+		}
+		if tmpl.Name != "" {
+			memory = tmpl.Memory
+			cores = tmpl.Cores
+			nicModel = tmpl.NICModel
+			if tmpl.Firmware == "ovmf" {
+				bios = DefaultOVMF
+			}
 		}
 
 		config := &VMConfig{
-			Name:   os.Args[2],
-			Memory: "2G",
-			Drives: []Drive{
-				{
-					Type: "img",
-				},
-				{
-					Type: "qcow2",
-					Path: diskPath,
-				},
-				{
-					Type: "iso",
-				},
-			},
-			Ports: []Port{
+			Name:      name,
+			UUID:      uuid,
+			Memory:    memory,
+			Cores:     cores,
+			BIOS:      bios,
+			CloudInit: cloudInit,
+			Drives:    drives,
+			NICs: []NIC{
 				{
-					Guest: 22,
-					Host:  2222,
+					Mode:  "user",
+					Model: nicModel,
+					Ports: []Port{
+						{
+							Guest: 22,
+							Host:  2222,
+						},
+					},
 				},
 			},
 		}
 
-		configPath, err := writeConfig(os.Args[2], config)
+		configPath, err := writeConfig(name, config)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -126,7 +263,150 @@ func main() {
 		if err := editor(configPath); err != nil {
 			fmt.Fprintln(os.Stderr, "editing config:", err)
 		}
+
+	case "stop":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "no stop name")
+			os.Exit(1)
+		}
+		if err := withQMP(os.Args[2], func(client *qmp.Client) error {
+			return client.PowerDown()
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "kill":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "no kill name")
+			os.Exit(1)
+		}
+		if err := withQMP(os.Args[2], func(client *qmp.Client) error {
+			return client.Quit()
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "status":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "no status name")
+			os.Exit(1)
+		}
+		if err := withQMP(os.Args[2], func(client *qmp.Client) error {
+			status, err := client.Status()
+			if err != nil {
+				return err
+			}
+			fmt.Println(status)
+			return nil
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "reset":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "no reset name")
+			os.Exit(1)
+		}
+		if err := withQMP(os.Args[2], func(client *qmp.Client) error {
+			return client.SystemReset()
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "disk":
+		if err := runDiskCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "templates":
+		if len(os.Args) < 3 || os.Args[2] != "list" {
+			fmt.Fprintln(os.Stderr, "usage: templates list")
+			os.Exit(1)
+		}
+		for _, tmpl := range templates.List() {
+			fmt.Printf("%s\tmemory=%s cores=%d firmware=%s\n", tmpl.Name, tmpl.Memory, tmpl.Cores, tmpl.Firmware)
+		}
+
+	case "snapshot":
+		if len(os.Args) < 4 {
+			fmt.Fprintln(os.Stderr, "usage: snapshot <name> <tag>")
+			os.Exit(1)
+		}
+		if err := withQMP(os.Args[2], func(client *qmp.Client) error {
+			out, err := client.SaveVM(os.Args[3])
+			if err != nil {
+				return err
+			}
+			if out != "" {
+				fmt.Println(out)
+			}
+			return recordSnapshot(os.Args[2], os.Args[3])
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+	case "restore":
+		if len(os.Args) < 4 {
+			fmt.Fprintln(os.Stderr, "usage: restore <name> <tag>")
+			os.Exit(1)
+		}
+		if err := withQMP(os.Args[2], func(client *qmp.Client) error {
+			out, err := client.LoadVM(os.Args[3])
+			if err != nil {
+				return err
+			}
+			if out != "" {
+				fmt.Println(out)
+			}
+			return nil
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// qmpSocketPath returns the path of the unix control socket qmgr asks QEMU
+// to listen on for the named VM.
+func qmpSocketPath(name string) (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("getting current user: %w", err)
 	}
+	return filepath.Join(usr.HomeDir, RunDir, name+".qmp"), nil
+}
+
+// pidFilePath returns the path of the pidfile qmgr asks QEMU to write for
+// the named VM.
+func pidFilePath(name string) (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("getting current user: %w", err)
+	}
+	return filepath.Join(usr.HomeDir, RunDir, name+".pid"), nil
+}
+
+// withQMP dials the named VM's QMP socket, runs fn against the connected
+// client, and closes the connection afterwards.
+func withQMP(name string, fn func(*qmp.Client) error) error {
+	socketPath, err := qmpSocketPath(name)
+	if err != nil {
+		return err
+	}
+
+	client, err := qmp.Dial(socketPath)
+	if err != nil {
+		return fmt.Errorf("connecting to qmp socket: %w", err)
+	}
+	defer client.Close()
+
+	return fn(client)
 }
 
 func listConfigs() ([]string, error) {
@@ -196,15 +476,74 @@ func writeConfig(name string, config *VMConfig) (string, error) {
 	return filePath, nil
 }
 
-func launchVM(config *VMConfig) error {
+func launchVM(config *VMConfig, snapshotTag string) error {
+	usr, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("getting current user: %w", err)
+	}
+	runDir := filepath.Join(usr.HomeDir, RunDir)
+	if err := os.MkdirAll(runDir, os.ModePerm); err != nil {
+		return fmt.Errorf("creating run directory: %w", err)
+	}
+
+	if snapshotTag != "" {
+		if err := validateSnapshotTag(config, snapshotTag); err != nil {
+			return err
+		}
+	}
+
+	socketPath, err := qmpSocketPath(config.Name)
+	if err != nil {
+		return err
+	}
+	pidPath, err := pidFilePath(config.Name)
+	if err != nil {
+		return err
+	}
+
+	qemuBin, err := qemuExec(config)
+	if err != nil {
+		return err
+	}
+
+	machine := config.Machine
+	if machine == "" {
+		machine = defaultMachine(config.Arch)
+	}
+	accel := config.Accel
+	if accel == "" {
+		accel = defaultAccel(config.Arch)
+	}
+	cpu := config.CPU
+	if cpu == "" {
+		cpu = defaultCPU(config.Arch, accel)
+	}
+
 	var args []string
+	args = append(args, "-qmp", fmt.Sprintf("unix:%s,server=on,wait=off", socketPath))
+	args = append(args, "-pidfile", pidPath)
 	args = append(args, "-m", config.Memory)
-	args = append(args, "-machine", "q35")
+	args = append(args, "-machine", fmt.Sprintf("%s,accel=%s", machine, accel))
+	if config.BIOS != "" {
+		args = append(args, "-bios", config.BIOS)
+	}
+	for _, pflash := range config.Pflash {
+		args = append(args, "-drive", fmt.Sprintf("if=pflash,format=raw,file=%s", pflash))
+	}
 	args = append(args, "-device", "qemu-xhci,id=xhci")
 	// args = append(args, "-device", "virtio-gpu")
 	args = append(args, "-device", "usb-kbd")
 	args = append(args, "-device", "usb-tablet")
-	args = append(args, "-device", "virtio-net,netdev=net0")
+	nicArgs, err := networkArgs(config)
+	if err != nil {
+		return err
+	}
+	args = append(args, nicArgs...)
+	ciArgs, err := cloudInitArgs(config)
+	if err != nil {
+		return err
+	}
+	args = append(args, ciArgs...)
 	for idx, drive := range config.Drives {
 		if drive.Path == "" {
 			continue
@@ -215,7 +554,17 @@ func launchVM(config *VMConfig) error {
 			args = append(args, "-drive", fmt.Sprintf("if=none,id=usb%d,format=raw,file=%s", idx, drive.Path))
 			args = append(args, "-device", fmt.Sprintf("usb-storage,bus=xhci.0,drive=usb%d", idx))
 		case "qcow2":
-			args = append(args, "-drive", fmt.Sprintf("if=virtio,format=qcow2,file=%s", drive.Path))
+			switch drive.Interface {
+			case "", "virtio":
+				args = append(args, "-drive", fmt.Sprintf("if=virtio,format=qcow2,file=%s", drive.Path))
+			case "ide":
+				args = append(args, "-drive", fmt.Sprintf("if=ide,format=qcow2,file=%s", drive.Path))
+			case "usb-storage":
+				args = append(args, "-drive", fmt.Sprintf("if=none,id=usb%d,format=qcow2,file=%s", idx, drive.Path))
+				args = append(args, "-device", fmt.Sprintf("usb-storage,bus=xhci.0,drive=usb%d", idx))
+			default:
+				return fmt.Errorf("unsupported disk interface %q", drive.Interface)
+			}
 		case "iso":
 			args = append(args, "-cdrom", drive.Path)
 		}
@@ -223,19 +572,18 @@ func launchVM(config *VMConfig) error {
 	if config.Cores == 0 {
 		config.Cores = uint(runtime.NumCPU())
 	}
-	args = append(args, "-enable-kvm", "-cpu", "host", "-smp", fmt.Sprintf("%d", config.Cores))
+	args = append(args, "-cpu", cpu, "-smp", fmt.Sprintf("%d", config.Cores))
 	if config.Fullscreen {
 		args = append(args, "-display", "gtk,full-screen=on")
 	}
-	if len(config.Ports) > 0 {
-		fwds := []string{}
-		for _, fwd := range config.Ports {
-			fwds = append(fwds, fmt.Sprintf("tcp::%d-:%d", fwd.Host, fwd.Guest))
-		}
-		args = append(args, "-netdev", fmt.Sprintf("user,id=net0,hostfwd=%s", strings.Join(fwds, ",")))
+	if snapshotTag != "" {
+		// -snapshot routes all writes to a temporary overlay qemu discards
+		// on exit, so resuming tag never mutates the base images; -loadvm
+		// then finds tag in the attached (unmodified) image's own header.
+		args = append(args, "-snapshot", "-loadvm", snapshotTag)
 	}
 
-	cmd := exec.Command(QemuExec, args...)
+	cmd := exec.Command(qemuBin, args...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -261,6 +609,19 @@ func newDisk(name, size string) (string, error) {
 	return filePath, nil
 }
 
+// newUUID generates a random RFC 4122 version 4 UUID, used to derive
+// per-VM tap/bridge MAC addresses that stay stable across runs.
+func newUUID() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("generating uuid: %w", err)
+	}
+	buf[6] = (buf[6] & 0x0f) | 0x40
+	buf[8] = (buf[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:16]), nil
+}
+
 func generateConfig() (*VMConfig, error) {
 	return nil, nil
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type NIC struct {
+	Mode  string `json:"mode"` // none, user, tap, bridge, socket, vde
+	Model string `json:"model,omitempty"`
+	MAC   string `json:"mac,omitempty"`
+
+	Ports []Port `json:"ports,omitempty"` // user mode
+
+	Ifname     string `json:"ifname,omitempty"` // tap mode
+	Script     string `json:"script,omitempty"`
+	Downscript string `json:"downscript,omitempty"`
+
+	Bridge string `json:"bridge,omitempty"` // bridge mode
+
+	Path string `json:"path,omitempty"` // socket/vde mode
+}
+
+var nicModels = map[string]bool{
+	"virtio-net": true,
+	"e1000":      true,
+	"rtl8139":    true,
+}
+
+var nicModes = map[string]bool{
+	"none":   true,
+	"user":   true,
+	"tap":    true,
+	"bridge": true,
+	"socket": true,
+	"vde":    true,
+}
+
+func nicMAC(vmName, vmUUID string, idx int, nic NIC) string {
+	if nic.MAC != "" {
+		return nic.MAC
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%d", vmName, vmUUID, idx)))
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", sum[0], sum[1], sum[2])
+}
+
+func networkArgs(config *VMConfig) ([]string, error) {
+	nics := config.NICs
+	if len(nics) == 0 {
+		nics = []NIC{{Mode: "user", Ports: config.Ports}}
+	}
+
+	var args []string
+	for idx, nic := range nics {
+		netArgs, deviceArgs, err := nicArgs(config, idx, nic)
+		if err != nil {
+			return nil, fmt.Errorf("nic %d: %w", idx, err)
+		}
+		if netArgs != "" {
+			args = append(args, "-netdev", netArgs)
+		}
+		if deviceArgs != "" {
+			args = append(args, "-device", deviceArgs)
+		}
+	}
+
+	return args, nil
+}
+
+func nicArgs(config *VMConfig, idx int, nic NIC) (netdev, device string, err error) {
+	if nic.Mode == "" {
+		nic.Mode = "user"
+	}
+	if !nicModes[nic.Mode] {
+		return "", "", fmt.Errorf("unsupported network mode %q", nic.Mode)
+	}
+
+	model := nic.Model
+	if model == "" {
+		model = "virtio-net"
+	}
+	if !nicModels[model] {
+		return "", "", fmt.Errorf("unsupported nic model %q", model)
+	}
+
+	id := fmt.Sprintf("net%d", idx)
+
+	switch nic.Mode {
+	case "none":
+		return "", "", nil
+
+	case "user":
+		fwds := []string{}
+		for _, fwd := range nic.Ports {
+			fwds = append(fwds, fmt.Sprintf("tcp::%d-:%d", fwd.Host, fwd.Guest))
+		}
+		netdev = fmt.Sprintf("user,id=%s", id)
+		if len(fwds) > 0 {
+			netdev += ",hostfwd=" + strings.Join(fwds, ",")
+		}
+
+	case "tap":
+		ifname := nic.Ifname
+		if ifname == "" {
+			ifname = "tap" + config.Name
+		}
+		if os.Geteuid() == 0 {
+			if err := ensureTap(ifname); err != nil {
+				return "", "", err
+			}
+		}
+		netdev = fmt.Sprintf("tap,id=%s,ifname=%s", id, ifname)
+		if nic.Script != "" {
+			netdev += ",script=" + nic.Script
+		} else {
+			netdev += ",script=no"
+		}
+		if nic.Downscript != "" {
+			netdev += ",downscript=" + nic.Downscript
+		} else {
+			netdev += ",downscript=no"
+		}
+
+	case "bridge":
+		if nic.Bridge == "" {
+			return "", "", fmt.Errorf("bridge mode requires a bridge name")
+		}
+		netdev = fmt.Sprintf("bridge,id=%s,br=%s", id, nic.Bridge)
+
+	case "socket":
+		if nic.Path == "" {
+			return "", "", fmt.Errorf("socket mode requires a path")
+		}
+		netdev = fmt.Sprintf("socket,id=%s,connect=%s", id, nic.Path)
+
+	case "vde":
+		if nic.Path == "" {
+			return "", "", fmt.Errorf("vde mode requires a switch path")
+		}
+		netdev = fmt.Sprintf("vde,id=%s,sock=%s", id, nic.Path)
+	}
+
+	mac := nicMAC(config.Name, config.UUID, idx, nic)
+	device = fmt.Sprintf("%s,netdev=%s,mac=%s", model, id, mac)
+
+	return netdev, device, nil
+}
+
+func ensureTap(ifname string) error {
+	if err := exec.Command("ip", "link", "show", ifname).Run(); err == nil {
+		return nil
+	}
+	if err := exec.Command("ip", "tuntap", "add", "dev", ifname, "mode", "tap").Run(); err != nil {
+		return fmt.Errorf("creating tap device %s: %w", ifname, err)
+	}
+	if err := exec.Command("ip", "link", "set", ifname, "up").Run(); err != nil {
+		return fmt.Errorf("bringing up tap device %s: %w", ifname, err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+// Package qmp is a minimal client for the QEMU Machine Protocol.
+package qmp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+type Version struct {
+	QEMU struct {
+		Major int `json:"major"`
+		Minor int `json:"minor"`
+		Micro int `json:"micro"`
+	} `json:"qemu"`
+	Package string `json:"package"`
+}
+
+type greeting struct {
+	QMP struct {
+		Version      Version  `json:"version"`
+		Capabilities []string `json:"capabilities"`
+	} `json:"QMP"`
+}
+
+type command struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+	ID        uint64      `json:"id"`
+}
+
+type response struct {
+	Return json.RawMessage `json:"return"`
+	Error  *Error          `json:"error"`
+	ID     uint64          `json:"id"`
+	Event  string          `json:"event"`
+}
+
+type Error struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("qmp: %s: %s", e.Class, e.Desc)
+}
+
+type Client struct {
+	conn    net.Conn
+	decoder *json.Decoder
+	nextID  uint64
+	Version Version
+}
+
+func Dial(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("dialing qmp socket: %w", err)
+	}
+
+	client := &Client{
+		conn:    conn,
+		decoder: json.NewDecoder(bufio.NewReader(conn)),
+	}
+
+	var greet greeting
+	if err := client.decoder.Decode(&greet); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading qmp greeting: %w", err)
+	}
+	client.Version = greet.QMP.Version
+
+	if _, err := client.Execute("qmp_capabilities", nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("negotiating qmp capabilities: %w", err)
+	}
+
+	return client, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) Execute(execute string, arguments interface{}) (json.RawMessage, error) {
+	id := atomic.AddUint64(&c.nextID, 1)
+
+	cmd := command{
+		Execute:   execute,
+		Arguments: arguments,
+		ID:        id,
+	}
+
+	enc, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling qmp command: %w", err)
+	}
+
+	if _, err := c.conn.Write(append(enc, '\n')); err != nil {
+		return nil, fmt.Errorf("writing qmp command: %w", err)
+	}
+
+	for {
+		var resp response
+		if err := c.decoder.Decode(&resp); err != nil {
+			return nil, fmt.Errorf("reading qmp response: %w", err)
+		}
+		if resp.Event != "" {
+			// Not a reply to our command, keep waiting.
+			continue
+		}
+		if resp.ID != id {
+			continue
+		}
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Return, nil
+	}
+}
+
+func (c *Client) HumanMonitorCommand(cmdLine string) (string, error) {
+	raw, err := c.Execute("human-monitor-command", map[string]string{
+		"command-line": cmdLine,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var out string
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return "", fmt.Errorf("decoding human-monitor-command output: %w", err)
+	}
+	return out, nil
+}
+
+func (c *Client) Status() (string, error) {
+	raw, err := c.Execute("query-status", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return "", fmt.Errorf("decoding query-status output: %w", err)
+	}
+	return status.Status, nil
+}
+
+func (c *Client) PowerDown() error {
+	_, err := c.Execute("system_powerdown", nil)
+	return err
+}
+
+func (c *Client) Quit() error {
+	_, err := c.Execute("quit", nil)
+	return err
+}
+
+func (c *Client) SystemReset() error {
+	_, err := c.Execute("system_reset", nil)
+	return err
+}
+
+func (c *Client) SaveVM(tag string) (string, error) {
+	if err := validateTag(tag); err != nil {
+		return "", err
+	}
+	return c.HumanMonitorCommand(fmt.Sprintf("savevm %s", tag))
+}
+
+func (c *Client) LoadVM(tag string) (string, error) {
+	if err := validateTag(tag); err != nil {
+		return "", err
+	}
+	return c.HumanMonitorCommand(fmt.Sprintf("loadvm %s", tag))
+}
+
+// validateTag rejects tags that would be split into extra HMP arguments
+// instead of erroring clearly.
+func validateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("qmp: snapshot tag must not be empty")
+	}
+	for _, r := range tag {
+		if unicode.IsSpace(r) {
+			return fmt.Errorf("qmp: snapshot tag %q must not contain whitespace", tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+// Package templates holds distro presets for `qmgr create --template`.
+package templates
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Template is a named preset for `create --template <name>`.
+type Template struct {
+	Name string
+
+	Memory string
+	Cores  uint
+
+	DiskInterface string // qemu if=: virtio, ide, usb-storage
+	NICModel      string // virtio-net, e1000, rtl8139
+	Firmware      string // bios or ovmf (UEFI)
+
+	ImageURL    string // cloud/CoreOS image, downloaded into DiskDir on first use
+	ImageSHA256 string // pins the expected checksum directly
+	ChecksumURL string // used instead, when ImageSHA256 is empty: a SHA256SUMS-style file to grep
+
+	CloudInit bool // expects a NoCloud/Ignition seed generated for first boot
+}
+
+var Presets = map[string]Template{
+	"alpine": {
+		Name:          "alpine",
+		Memory:        "1G",
+		Cores:         1,
+		DiskInterface: "virtio",
+		NICModel:      "virtio-net",
+		Firmware:      "bios",
+	},
+	"ubuntu-cloud": {
+		Name:          "ubuntu-cloud",
+		Memory:        "2G",
+		Cores:         2,
+		DiskInterface: "virtio",
+		NICModel:      "virtio-net",
+		Firmware:      "bios",
+		ImageURL:      "https://cloud-images.ubuntu.com/releases/22.04/release/ubuntu-22.04-server-cloudimg-amd64.img",
+		ChecksumURL:   "https://cloud-images.ubuntu.com/releases/22.04/release/SHA256SUMS",
+		CloudInit:     true,
+	},
+	"debian-cloud": {
+		Name:          "debian-cloud",
+		Memory:        "2G",
+		Cores:         2,
+		DiskInterface: "virtio",
+		NICModel:      "virtio-net",
+		Firmware:      "bios",
+		ImageURL:      "https://cloud.debian.org/images/cloud/bookworm/latest/debian-12-genericcloud-amd64.qcow2",
+		ChecksumURL:   "https://cloud.debian.org/images/cloud/bookworm/latest/SHA256SUMS",
+		CloudInit:     true,
+	},
+	// fedora-coreos is dropped for now: Fedora CoreOS ships no stable
+	// "latest" qcow2 URL (releases are versioned per-stream), so there's
+	// no fixed ImageURL/ChecksumURL to pin here yet.
+	"windows": {
+		Name:          "windows",
+		Memory:        "4G",
+		Cores:         4,
+		DiskInterface: "ide",
+		NICModel:      "e1000",
+		Firmware:      "ovmf",
+	},
+}
+
+func Get(name string) (Template, bool) {
+	tmpl, ok := Presets[name]
+	return tmpl, ok
+}
+
+func List() []Template {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	// Small fixed set; a simple insertion sort keeps this dependency-free.
+	for i := 1; i < len(names); i++ {
+		for j := i; j > 0 && names[j-1] > names[j]; j-- {
+			names[j-1], names[j] = names[j], names[j-1]
+		}
+	}
+
+	tmpls := make([]Template, 0, len(names))
+	for _, name := range names {
+		tmpls = append(tmpls, Presets[name])
+	}
+	return tmpls
+}
+
+// Fetch downloads a template's image into destPath, resuming a partial
+// download if one is already present. No-op if destPath already exists
+// and matches the checksum.
+func Fetch(t Template, destPath string) error {
+	if t.ImageURL == "" {
+		return fmt.Errorf("template %s has no image to fetch", t.Name)
+	}
+
+	want, err := t.checksum()
+	if err != nil {
+		return err
+	}
+
+	if verifyChecksum(destPath, want) == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), os.ModePerm); err != nil {
+		return fmt.Errorf("creating image directory: %w", err)
+	}
+
+	partPath := destPath + ".part"
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, t.ImageURL, nil)
+	if err != nil {
+		return fmt.Errorf("building image request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("downloading image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("downloading image: unexpected status %s", resp.Status)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if resp.StatusCode == http.StatusPartialContent {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("opening image file: %w", err)
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return fmt.Errorf("writing image file: %w", err)
+	}
+	out.Close()
+
+	if err := verifyChecksum(partPath, want); err != nil {
+		return err
+	}
+
+	return os.Rename(partPath, destPath)
+}
+
+func (t Template) checksum() (string, error) {
+	if t.ImageSHA256 != "" {
+		return t.ImageSHA256, nil
+	}
+	if t.ChecksumURL == "" {
+		return "", nil
+	}
+	return fetchChecksum(t.ChecksumURL, path.Base(t.ImageURL))
+}
+
+func fetchChecksum(url, filename string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("fetching checksum list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching checksum list: unexpected status %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if strings.TrimPrefix(fields[1], "*") == filename {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("reading checksum list: %w", err)
+	}
+
+	return "", fmt.Errorf("%s not found in %s", filename, url)
+}
+
+func verifyChecksum(path, want string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if want == "" {
+		return nil
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return err
+	}
+
+	got := hex.EncodeToString(hasher.Sum(nil))
+	if got != want {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, want)
+	}
+	return nil
+}